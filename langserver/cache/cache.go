@@ -0,0 +1,175 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache holds the set of documents currently open in the editor,
+// together with the most recently computed parse result for each of them.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/slrtbtfs/promql-lsp/vendored/go-tools/lsp/protocol"
+)
+
+// Document represents a single open text document, together with the most
+// recently computed parse result for it.
+type Document struct {
+	mu sync.RWMutex
+
+	uri string
+
+	version         float64
+	versionCtx      context.Context
+	obsoleteVersion context.CancelFunc
+
+	diagnostics []protocol.Diagnostic
+}
+
+// GetVersion returns the document's current version. It returns an error once
+// ctx has been cancelled, which happens as soon as a newer version of the
+// document arrives, so that callers working off a stale version can bail out.
+func (d *Document) GetVersion(ctx context.Context) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.version, nil
+}
+
+// GetDiagnostics returns the diagnostics computed for the document's current version.
+func (d *Document) GetDiagnostics(ctx context.Context) ([]protocol.Diagnostic, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.diagnostics, nil
+}
+
+// SetContent records a new version of the document, replacing its cached
+// diagnostics and cancelling the previous version's context.
+func (d *Document) SetContent(ctx context.Context, version float64, diagnostics []protocol.Diagnostic) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.obsoleteVersion != nil {
+		d.obsoleteVersion()
+	}
+
+	d.version = version
+	d.versionCtx, d.obsoleteVersion = context.WithCancel(ctx)
+	d.diagnostics = diagnostics
+}
+
+// DocumentCache holds the set of documents currently open in the editor.
+type DocumentCache struct {
+	mu        sync.RWMutex
+	documents map[string]*Document
+}
+
+// GetDocument looks up an open document by URI, returning its current version
+// context alongside it.
+func (c *DocumentCache) GetDocument(uri string) (*Document, context.Context, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	d, ok := c.documents[uri]
+	if !ok {
+		return nil, nil, fmt.Errorf("document %s is not open", uri)
+	}
+
+	d.mu.RLock()
+	ctx := d.versionCtx
+	d.mu.RUnlock()
+
+	return d, ctx, nil
+}
+
+// AddDocument opens uri in the cache and returns its Document.
+func (c *DocumentCache) AddDocument(uri string) *Document {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.documents == nil {
+		c.documents = make(map[string]*Document)
+	}
+
+	d := &Document{uri: uri, versionCtx: context.Background()}
+	c.documents[uri] = d
+
+	return d
+}
+
+// RemoveDocument closes uri, removing it from the cache.
+func (c *DocumentCache) RemoveDocument(uri string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.documents, uri)
+}
+
+// Len reports the number of documents currently open in the cache.
+func (c *DocumentCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.documents)
+}
+
+// DocumentURIs returns the URIs of every document currently open in the cache.
+func (c *DocumentCache) DocumentURIs() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	uris := make([]string, 0, len(c.documents))
+	for uri := range c.documents {
+		uris = append(uris, uri)
+	}
+
+	return uris
+}
+
+// ParseResult is a debug-oriented snapshot of a document's last cached parse result.
+type ParseResult struct {
+	URI             string  `json:"uri"`
+	Version         float64 `json:"version"`
+	DiagnosticCount int     `json:"diagnosticCount"`
+}
+
+// ParseResults returns a debug snapshot of every document's last cached parse
+// result, for consumption by the /state debug endpoint.
+func (c *DocumentCache) ParseResults() []ParseResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	results := make([]ParseResult, 0, len(c.documents))
+
+	for uri, d := range c.documents {
+		d.mu.RLock()
+		results = append(results, ParseResult{
+			URI:             uri,
+			Version:         d.version,
+			DiagnosticCount: len(d.diagnostics),
+		})
+		d.mu.RUnlock()
+	}
+
+	return results
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+// Config configures a Server instance, or a set of Server instances spawned
+// by RunTCPServers.
+type Config struct {
+	// RPCTrace enables tracing of the jsonrpc2 connection on stderr.
+	// Valid values are "", "text" and "json".
+	RPCTrace string
+
+	// PrometheusURL is the Prometheus instance new Server instances connect to.
+	PrometheusURL string
+
+	// MetricsListenAddr, if non empty, serves Prometheus self-metrics for this
+	// process on this address under /metrics.
+	MetricsListenAddr string
+
+	// DebugListenAddr, if non empty, serves pprof, health and state debug
+	// endpoints for this process on this address.
+	DebugListenAddr string
+
+	// MaxConnections bounds the number of concurrent client connections
+	// RunTCPServers accepts. Zero means unbounded.
+	MaxConnections int
+
+	// WebsocketOriginAllowList restricts the Origin headers WebsocketServer
+	// accepts connections from. An empty list accepts requests without an
+	// Origin header and requests whose Origin host matches the request Host.
+	WebsocketOriginAllowList []string
+}
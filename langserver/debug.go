@@ -0,0 +1,106 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+
+	"github.com/slrtbtfs/promql-lsp/langserver/cache"
+)
+
+// serveDebug serves pprof, health and state debug endpoints for every
+// connection tracked by live on addr, until ctx is cancelled. A single
+// serveDebug is meant to run per process: live is shared by every connection
+// spawned from the same SharedServers, so these endpoints report on the whole
+// process rather than on whichever connection happened to start first.
+func serveDebug(ctx context.Context, addr string, live *liveServers) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("/healthz", live.handleHealthz)
+	mux.HandleFunc("/ready", live.handleReady)
+	mux.HandleFunc("/state", live.handleState)
+
+	httpServer := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		_ = httpServer.Close()
+	}()
+
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintln(os.Stderr, "Debug server failed:", err)
+	}
+}
+
+// handleHealthz reports the number of live connections in each serverState,
+// as JSON. With RunTCPServers/WebsocketServer multiplexing many independent
+// LSP sessions through one process, there is no single serverState to report
+// any more, so this reports a breakdown instead of one number.
+func (live *liveServers) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(live.stateCounts()); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to encode healthz state:", err)
+	}
+}
+
+// handleReady reports 200 once at least one connection has completed its
+// "initialized" handshake, and 503 otherwise.
+func (live *liveServers) handleReady(w http.ResponseWriter, _ *http.Request) {
+	if !live.anyInitialized() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// debugState is the JSON shape returned by /state.
+type debugState struct {
+	OpenDocuments []string            `json:"openDocuments"`
+	ParseResults  []cache.ParseResult `json:"parseResults"`
+}
+
+// handleState dumps the URIs of currently open documents across every live
+// connection, along with each document's last cached parse result.
+func (live *liveServers) handleState(w http.ResponseWriter, _ *http.Request) {
+	results := live.parseResults()
+
+	uris := make([]string, len(results))
+	for i, result := range results {
+		uris[i] = result.URI
+	}
+
+	state := debugState{
+		OpenDocuments: uris,
+		ParseResults:  results,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to encode debug state:", err)
+	}
+}
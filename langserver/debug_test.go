@@ -0,0 +1,100 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"testing"
+)
+
+func setState(s *server, state serverState) {
+	s.stateMu.Lock()
+	s.state = state
+	s.stateMu.Unlock()
+}
+
+func TestLiveServers_ParseResults_CoversEveryConnection(t *testing.T) {
+	live := newLiveServers()
+
+	first := &server{}
+	first.cache.AddDocument("file:///a.promql")
+	live.add(first)
+
+	second := &server{}
+	second.cache.AddDocument("file:///b.promql")
+	live.add(second)
+
+	results := live.parseResults()
+	if len(results) != 2 {
+		t.Fatalf("parseResults() returned %d results, want 2", len(results))
+	}
+
+	uris := map[string]bool{}
+	for _, r := range results {
+		uris[r.URI] = true
+	}
+
+	if !uris["file:///a.promql"] || !uris["file:///b.promql"] {
+		t.Fatalf("parseResults() = %+v, missing a connection's document", results)
+	}
+}
+
+func TestLiveServers_StateCounts(t *testing.T) {
+	live := newLiveServers()
+
+	a := &server{}
+	setState(a, serverInitialized)
+	live.add(a)
+
+	b := &server{}
+	setState(b, serverInitializing)
+	live.add(b)
+
+	c := &server{}
+	setState(c, serverInitialized)
+	live.add(c)
+
+	counts := live.stateCounts()
+
+	if counts["initialized"] != 2 {
+		t.Fatalf("stateCounts()[initialized] = %d, want 2", counts["initialized"])
+	}
+
+	if counts["initializing"] != 1 {
+		t.Fatalf("stateCounts()[initializing] = %d, want 1", counts["initializing"])
+	}
+}
+
+func TestLiveServers_AnyInitialized(t *testing.T) {
+	live := newLiveServers()
+
+	if live.anyInitialized() {
+		t.Fatal("anyInitialized() on an empty registry should be false")
+	}
+
+	notReady := &server{}
+	setState(notReady, serverInitializing)
+	live.add(notReady)
+
+	if live.anyInitialized() {
+		t.Fatal("anyInitialized() should be false when no connection has initialized yet")
+	}
+
+	ready := &server{}
+	setState(ready, serverInitialized)
+	live.add(ready)
+
+	if !live.anyInitialized() {
+		t.Fatal("anyInitialized() should be true once one connection has initialized")
+	}
+}
@@ -17,12 +17,15 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/slrtbtfs/promql-lsp/vendored/go-tools/lsp/protocol"
 )
 
 // nolint:funlen
 func (s *Server) diagnostics(uri string) {
+	defer s.metrics.observeRequest("diagnostics", time.Now())
+
 	d, ctx, err := s.cache.GetDocument(uri)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Document %v doesn't exist any more", uri)
@@ -45,7 +48,10 @@ func (s *Server) diagnostics(uri string) {
 
 	reply.Diagnostics = diagnostics
 
-	if err = s.client.PublishDiagnostics(ctx, reply); err != nil {
+	err = s.client.PublishDiagnostics(ctx, reply)
+	s.metrics.observeDiagnosticsPublish(err)
+
+	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to publish diagnostics")
 		fmt.Fprintln(os.Stderr, err.Error())
 	}
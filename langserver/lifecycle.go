@@ -0,0 +1,40 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+
+	"github.com/slrtbtfs/promql-lsp/vendored/go-tools/lsp/protocol"
+)
+
+// Initialized is called once the client has sent the "initialized"
+// notification. It is the point at which /ready starts reporting healthy.
+func (s *server) Initialized(_ context.Context, _ *protocol.InitializedParams) error {
+	s.stateMu.Lock()
+	s.state = serverInitialized
+	s.stateMu.Unlock()
+
+	return nil
+}
+
+// Shutdown is called once the client requests a shutdown. /healthz reports
+// the serverShutDown state from this point on.
+func (s *server) Shutdown(_ context.Context) error {
+	s.stateMu.Lock()
+	s.state = serverShutDown
+	s.stateMu.Unlock()
+
+	return nil
+}
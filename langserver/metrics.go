@@ -0,0 +1,111 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	promclient "github.com/slrtbtfs/promql-lsp/prometheus"
+)
+
+// metrics bundles the self-metrics a Server reports about itself.
+type metrics struct {
+	registry *prometheus.Registry
+
+	openDocuments    prometheus.GaugeFunc
+	requestLatency   *prometheus.HistogramVec
+	diagnosticsTotal *prometheus.CounterVec
+}
+
+// newMetrics creates a fresh metrics registry and registers both the
+// langserver's own metrics and the prometheus package's client metrics with
+// it. live is consulted on every scrape to report the open documents gauge,
+// so the value always reflects every connection sharing this registry, not
+// just whichever one happened to update it last.
+func newMetrics(live *liveServers) *metrics {
+	registry := prometheus.NewRegistry()
+
+	m := &metrics{
+		registry: registry,
+		openDocuments: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "promql_langserver",
+			Name:      "open_documents",
+			Help:      "Number of documents currently held open across every connection sharing this registry.",
+		}, live.openDocumentCount),
+		requestLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "promql_langserver",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of LSP requests, by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		diagnosticsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "promql_langserver",
+			Name:      "diagnostics_publish_total",
+			Help:      "Number of diagnostics publish attempts, by outcome.",
+		}, []string{"outcome"}),
+	}
+
+	registry.MustRegister(m.openDocuments, m.requestLatency, m.diagnosticsTotal)
+
+	if err := promclient.RegisterMetrics(registry); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to register prometheus client metrics:", err)
+	}
+
+	return m
+}
+
+// observeRequest records the latency of an LSP request handled under method.
+// Diagnostics publication is the only request this snapshot of the LSP
+// handler set implements; hover and completion aren't implemented here, so
+// there's nothing yet to label "hover"/"completion" with. Any future handler
+// should call this the same way diagnostics does, so request_duration_seconds
+// picks it up automatically.
+func (m *metrics) observeRequest(method string, start time.Time) {
+	m.requestLatency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// observeDiagnosticsPublish records the outcome of a diagnostics publish attempt.
+func (m *metrics) observeDiagnosticsPublish(err error) {
+	if err != nil {
+		m.diagnosticsTotal.WithLabelValues("error").Inc()
+		return
+	}
+
+	m.diagnosticsTotal.WithLabelValues("success").Inc()
+}
+
+// serveMetrics serves the given registry's metrics on addr under /metrics
+// until ctx is cancelled.
+func serveMetrics(ctx context.Context, addr string, registry *prometheus.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	httpServer := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		_ = httpServer.Close()
+	}()
+
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintln(os.Stderr, "Metrics server failed:", err)
+	}
+}
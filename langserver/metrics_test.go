@@ -0,0 +1,43 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import "testing"
+
+func TestLiveServers_OpenDocumentCount_SumsAcrossConnections(t *testing.T) {
+	live := newLiveServers()
+
+	if got := live.openDocumentCount(); got != 0 {
+		t.Fatalf("openDocumentCount() on an empty registry = %v, want 0", got)
+	}
+
+	first := &server{}
+	first.cache.AddDocument("file:///a.promql")
+	first.cache.AddDocument("file:///b.promql")
+	live.add(first)
+
+	second := &server{}
+	second.cache.AddDocument("file:///c.promql")
+	live.add(second)
+
+	if got := live.openDocumentCount(); got != 3 {
+		t.Fatalf("openDocumentCount() = %v, want 3", got)
+	}
+
+	live.remove(first)
+
+	if got := live.openDocumentCount(); got != 1 {
+		t.Fatalf("openDocumentCount() after remove = %v, want 1", got)
+	}
+}
@@ -20,6 +20,7 @@ package langserver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -48,6 +49,8 @@ type server struct {
 
 	config *Config
 
+	metrics *metrics
+
 	prometheus api.Client
 
 	lifetime context.Context
@@ -63,13 +66,151 @@ const (
 	serverShutDown
 )
 
+// String renders state the way it's reported by /healthz, so the debug
+// endpoint's JSON output is self-explanatory without cross-referencing this file.
+func (state serverState) String() string {
+	switch state {
+	case serverCreated:
+		return "created"
+	case serverInitializing:
+		return "initializing"
+	case serverInitialized:
+		return "initialized"
+	case serverShutDown:
+		return "shutDown"
+	default:
+		return "unknown"
+	}
+}
+
+// liveServers tracks every server instance currently live in the process, so
+// that metrics and debug endpoints shared across connections (see
+// SharedServers) can aggregate over all of them rather than just one.
+type liveServers struct {
+	mu      sync.Mutex
+	servers map[*server]struct{}
+}
+
+func newLiveServers() *liveServers {
+	return &liveServers{servers: make(map[*server]struct{})}
+}
+
+func (live *liveServers) add(s *server) {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	live.servers[s] = struct{}{}
+}
+
+func (live *liveServers) remove(s *server) {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	delete(live.servers, s)
+}
+
+// openDocumentCount sums the number of open documents across every live
+// connection's cache.
+func (live *liveServers) openDocumentCount() float64 {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	total := 0
+	for s := range live.servers {
+		total += s.cache.Len()
+	}
+
+	return float64(total)
+}
+
+// parseResults returns the last cached parse result of every document open on
+// every live connection.
+func (live *liveServers) parseResults() []cache.ParseResult {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	results := make([]cache.ParseResult, 0, len(live.servers))
+	for s := range live.servers {
+		results = append(results, s.cache.ParseResults()...)
+	}
+
+	return results
+}
+
+// stateCounts tallies live connections by serverState, for /healthz.
+func (live *liveServers) stateCounts() map[string]int {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	counts := make(map[string]int, len(live.servers))
+
+	for s := range live.servers {
+		s.stateMu.Lock()
+		counts[s.state.String()]++
+		s.stateMu.Unlock()
+	}
+
+	return counts
+}
+
+// anyInitialized reports whether at least one live connection has completed
+// its "initialized" handshake.
+func (live *liveServers) anyInitialized() bool {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	for s := range live.servers {
+		s.stateMu.Lock()
+		initialized := s.state >= serverInitialized
+		s.stateMu.Unlock()
+
+		if initialized {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Run starts the language server instance
 func (s Server) Run() error {
 	return s.server.Conn.Run(s.server.lifetime)
 }
 
-// ServerFromStream generates a Server from a jsonrpc2.Stream
-func ServerFromStream(ctx context.Context, stream jsonrpc2.Stream, config *Config) (context.Context, Server) {
+// SharedServers bundles the metrics registry and the live-connection tracker
+// that every Server spawned from the same process shares, so that the
+// /metrics and /state/healthz/ready debug endpoints aggregate across every
+// connection instead of reflecting whichever one happened to start first.
+// Construct one with NewSharedServers and pass it to every
+// StdioServer/RunTCPServers/WebsocketServer/ServerFromStream call that should
+// share the same metrics registry and debug listener.
+type SharedServers struct {
+	metrics *metrics
+	live    *liveServers
+}
+
+// NewSharedServers creates the metrics registry and live-connection tracker
+// for config, and starts their HTTP listeners if configured. ctx bounds the
+// listeners' lifetime.
+func NewSharedServers(ctx context.Context, config *Config) *SharedServers {
+	live := newLiveServers()
+	m := newMetrics(live)
+
+	if config.MetricsListenAddr != "" {
+		go serveMetrics(ctx, config.MetricsListenAddr, m.registry)
+	}
+
+	if config.DebugListenAddr != "" {
+		go serveDebug(ctx, config.DebugListenAddr, live)
+	}
+
+	return &SharedServers{metrics: m, live: live}
+}
+
+// ServerFromStream generates a Server from a jsonrpc2.Stream. shared is
+// shared with every other Server spawned from the same process, so that the
+// metrics and debug endpoints it started aggregate across all of them.
+func ServerFromStream(ctx context.Context, stream jsonrpc2.Stream, shared *SharedServers, config *Config) (context.Context, Server) {
 	s := &server{}
 
 	switch config.RPCTrace {
@@ -95,31 +236,136 @@ func ServerFromStream(ctx context.Context, stream jsonrpc2.Stream, config *Confi
 	ctx, s.Conn, s.client = protocol.NewServer(ctx, stream, s)
 	s.config = config
 
+	s.stateMu.Lock()
+	s.state = serverInitializing
+	s.stateMu.Unlock()
+
 	s.lifetime, s.exit = context.WithCancel(ctx)
 
+	s.metrics = shared.metrics
+
+	shared.live.add(s)
+
+	go func() {
+		<-s.lifetime.Done()
+		shared.live.remove(s)
+	}()
+
 	return ctx, Server{s}
 }
 
-// TCPServer generates a Server listening on the provided TCP Address, creating a new language Server
-// instance for every connection
-func RunTCPServers(ctx context.Context, addr string, config *Config) error {
+// TCPServerSet tracks the language Server instances spawned by RunTCPServers
+// so that they can be shut down gracefully.
+type TCPServerSet struct {
+	ln      net.Listener
+	shared  *SharedServers
+	servers sync.Map // map[string]*trackedConn
+	sem     chan struct{}
+	wg      sync.WaitGroup
+}
+
+// trackedConn is the bookkeeping RunTCPServers keeps per live connection.
+type trackedConn struct {
+	exit func()
+}
+
+// RunTCPServers generates a Server listening on the provided TCP Address, creating a new language Server
+// instance for every connection. Every connection shares shared's metrics registry and debug listener.
+// The returned TCPServerSet can be used to shut every spawned Server down.
+func RunTCPServers(ctx context.Context, addr string, shared *SharedServers, config *Config) (*TCPServerSet, error) {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	set := &TCPServerSet{ln: ln, shared: shared}
+
+	if config.MaxConnections > 0 {
+		set.sem = make(chan struct{}, config.MaxConnections)
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	go set.acceptLoop(ctx, config)
+
+	return set, nil
+}
+
+func (set *TCPServerSet) acceptLoop(ctx context.Context, config *Config) {
 	for {
-		conn, err := ln.Accept()
+		conn, err := set.ln.Accept()
 		if err != nil {
-			return err
+			return
+		}
+
+		if set.sem != nil {
+			select {
+			case set.sem <- struct{}{}:
+			default:
+				fmt.Fprintln(os.Stderr, "Too many connections, rejecting", conn.RemoteAddr())
+				_ = conn.Close()
+
+				continue
+			}
 		}
 
-		go ServerFromStream(ctx, jsonrpc2.NewHeaderStream(conn, conn), config)
+		set.wg.Add(1)
+
+		go set.serve(ctx, conn, config)
+	}
+}
+
+func (set *TCPServerSet) serve(ctx context.Context, conn net.Conn, config *Config) {
+	defer set.wg.Done()
+
+	if set.sem != nil {
+		defer func() { <-set.sem }()
+	}
+
+	remoteAddr := conn.RemoteAddr().String()
+
+	_, s := ServerFromStream(ctx, jsonrpc2.NewHeaderStream(conn, conn), set.shared, config)
+
+	set.servers.Store(remoteAddr, &trackedConn{exit: s.server.exit})
+	defer set.servers.Delete(remoteAddr)
+
+	if err := s.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "Connection from", remoteAddr, "terminated:", err)
 	}
 }
 
+// Shutdown cancels the lifetime of every Server spawned by set, waits for their
+// Conn.Run to return or for ctx to expire, and then closes the listener.
+func (set *TCPServerSet) Shutdown(ctx context.Context) error {
+	set.servers.Range(func(_, value interface{}) bool {
+		value.(*trackedConn).exit()
+		return true
+	})
+
+	done := make(chan struct{})
+
+	go func() {
+		set.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	if err := set.ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+
+	return nil
+}
+
 // StdioServer generates a Server talking to stdio
-func StdioServer(ctx context.Context, config *Config) (context.Context, Server) {
+func StdioServer(ctx context.Context, shared *SharedServers, config *Config) (context.Context, Server) {
 	stream := jsonrpc2.NewHeaderStream(os.Stdin, os.Stdout)
-	return ServerFromStream(ctx, stream, config)
+	return ServerFromStream(ctx, stream, shared, config)
 }
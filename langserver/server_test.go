@@ -0,0 +1,98 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestListener(t *testing.T) net.Listener {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to open test listener: %v", err)
+	}
+
+	return ln
+}
+
+func TestTCPServerSet_Shutdown_WaitsForConnectionsToExit(t *testing.T) {
+	set := &TCPServerSet{ln: newTestListener(t)}
+
+	exited := make(chan struct{})
+	finished := make(chan struct{})
+	set.servers.Store("conn-1", &trackedConn{exit: func() { close(exited) }})
+	set.wg.Add(1)
+
+	go func() {
+		<-exited
+		close(finished)
+		set.wg.Done()
+	}()
+
+	if err := set.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	select {
+	case <-exited:
+	default:
+		t.Fatal("Shutdown returned without calling every tracked connection's exit")
+	}
+
+	select {
+	case <-finished:
+	default:
+		t.Fatal("Shutdown returned before the tracked connection finished")
+	}
+}
+
+func TestTCPServerSet_Shutdown_ReturnsOnContextDeadline(t *testing.T) {
+	set := &TCPServerSet{ln: newTestListener(t)}
+
+	// A connection that never calls wg.Done(), e.g. because its Conn.Run is
+	// stuck, must not be able to block Shutdown forever.
+	set.servers.Store("conn-1", &trackedConn{exit: func() {}})
+	set.wg.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if err := set.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Shutdown took %v, expected it to return once ctx expired", elapsed)
+	}
+}
+
+func TestTCPServerSet_Shutdown_ClosesListener(t *testing.T) {
+	ln := newTestListener(t)
+	set := &TCPServerSet{ln: ln}
+
+	if err := set.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Fatal("expected the listener to be closed after Shutdown")
+	}
+}
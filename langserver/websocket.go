@@ -0,0 +1,248 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/slrtbtfs/promql-lsp/vendored/go-tools/jsonrpc2"
+)
+
+// WebsocketServerSet tracks the language Server instances spawned by WebsocketServer.
+type WebsocketServerSet struct {
+	shared  *SharedServers
+	servers sync.Map // map[*byte]*trackedConn, keyed by a unique per-connection token
+	wg      sync.WaitGroup
+}
+
+// WebsocketServer registers a handler on pattern that upgrades incoming
+// WebSocket connections into jsonrpc2 streams and hands them to
+// ServerFromStream, creating a new language Server instance per connection.
+// Every connection shares shared's metrics registry and debug listener, the
+// same as StdioServer and RunTCPServers connections do when passed the same
+// SharedServers. This unlocks browser based editors (Monaco, CodeMirror LSP
+// clients) talking PromQL without spawning a subprocess. TLS, if desired, is
+// the caller's responsibility: mux is meant to be mounted on an already
+// configured http.Server.
+func WebsocketServer(ctx context.Context, mux *http.ServeMux, pattern string, shared *SharedServers, config *Config) *WebsocketServerSet {
+	upgrader := &websocket.Upgrader{
+		CheckOrigin: allowedOrigin(config.WebsocketOriginAllowList),
+	}
+
+	set := &WebsocketServerSet{shared: shared}
+
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		wsConn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to upgrade websocket connection:", err)
+			return
+		}
+
+		set.wg.Add(1)
+
+		go set.serve(ctx, wsConn, config)
+	})
+
+	return set
+}
+
+// allowedOrigin builds a websocket.Upgrader.CheckOrigin function from an
+// allow-list. An empty allow-list falls back to requiring the Origin to match
+// the request Host, same as gorilla/websocket's own default.
+func allowedOrigin(allowList []string) func(r *http.Request) bool {
+	if len(allowList) == 0 {
+		return func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+
+			originURL, err := url.Parse(origin)
+
+			return err == nil && strings.EqualFold(originURL.Host, r.Host)
+		}
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		for _, allowed := range allowList {
+			if origin == allowed {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func (set *WebsocketServerSet) serve(ctx context.Context, wsConn *websocket.Conn, config *Config) {
+	defer set.wg.Done()
+	defer wsConn.Close() // nolint: errcheck
+
+	// RemoteAddr can collide across connections sharing a NAT or a reverse
+	// proxy, so every connection gets its own tracking key.
+	connID := new(byte)
+
+	rw := &wsReadWriter{conn: wsConn}
+
+	_, s := ServerFromStream(ctx, jsonrpc2.NewHeaderStream(rw, rw), set.shared, config)
+
+	set.servers.Store(connID, &trackedConn{exit: s.server.exit})
+	defer set.servers.Delete(connID)
+
+	if err := s.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "Websocket connection from", wsConn.RemoteAddr(), "terminated:", err)
+	}
+}
+
+// Shutdown cancels the lifetime of every Server spawned by set and waits for
+// their Conn.Run to return, or for ctx to expire.
+func (set *WebsocketServerSet) Shutdown(ctx context.Context) error {
+	set.servers.Range(func(_, value interface{}) bool {
+		value.(*trackedConn).exit()
+		return true
+	})
+
+	done := make(chan struct{})
+
+	go func() {
+		set.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	return nil
+}
+
+// websocketConn is the subset of *websocket.Conn that wsReadWriter needs. It
+// exists so tests can exercise wsReadWriter against a fake, without opening a
+// real network connection.
+type websocketConn interface {
+	NextReader() (int, io.Reader, error)
+	WriteMessage(messageType int, data []byte) error
+}
+
+// wsReadWriter adapts a *websocket.Conn to io.Reader/io.Writer so it can be
+// wrapped by jsonrpc2.NewHeaderStream like any other stdio/TCP connection.
+type wsReadWriter struct {
+	conn websocketConn
+	r    io.Reader
+
+	// writeMu serializes Write, both because a single LSP message arrives as
+	// several Write calls (header, then body) that must land in one WS frame,
+	// and because gorilla/websocket forbids concurrent writers, while jsonrpc2
+	// may write responses and notifications from different goroutines.
+	writeMu  sync.Mutex
+	writeBuf []byte
+}
+
+func (w *wsReadWriter) Read(p []byte) (int, error) {
+	for {
+		if w.r == nil {
+			_, r, err := w.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+
+			w.r = r
+		}
+
+		n, err := w.r.Read(p)
+		if err == io.EOF {
+			w.r = nil
+
+			if n == 0 {
+				continue
+			}
+
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+// Write buffers p until it has accumulated one full Content-Length-framed
+// jsonrpc2 message, then sends that message as a single WebSocket text frame.
+// jsonrpc2.headerStream.Write emits the header and the body as separate Write
+// calls, so writing each call's bytes straight to the connection would split
+// a single LSP message across two frames.
+func (w *wsReadWriter) Write(p []byte) (int, error) {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	w.writeBuf = append(w.writeBuf, p...)
+
+	for {
+		headerEnd := bytes.Index(w.writeBuf, []byte("\r\n\r\n"))
+		if headerEnd == -1 {
+			return len(p), nil
+		}
+
+		contentLength, err := parseContentLength(w.writeBuf[:headerEnd])
+		if err != nil {
+			return 0, err
+		}
+
+		messageEnd := headerEnd + len("\r\n\r\n") + contentLength
+		if len(w.writeBuf) < messageEnd {
+			return len(p), nil
+		}
+
+		if err := w.conn.WriteMessage(websocket.TextMessage, w.writeBuf[:messageEnd]); err != nil {
+			return 0, err
+		}
+
+		if remaining := w.writeBuf[messageEnd:]; len(remaining) > 0 {
+			w.writeBuf = append([]byte(nil), remaining...)
+		} else {
+			w.writeBuf = nil
+		}
+	}
+}
+
+// parseContentLength extracts the value of the Content-Length header from a
+// block of \r\n-separated jsonrpc2 header lines.
+func parseContentLength(header []byte) (int, error) {
+	for _, line := range bytes.Split(header, []byte("\r\n")) {
+		name, value, found := bytes.Cut(line, []byte(":"))
+		if !found || !strings.EqualFold(string(bytes.TrimSpace(name)), "Content-Length") {
+			continue
+		}
+
+		contentLength, err := strconv.Atoi(string(bytes.TrimSpace(value)))
+		if err != nil {
+			return 0, fmt.Errorf("invalid Content-Length header: %w", err)
+		}
+
+		return contentLength, nil
+	}
+
+	return 0, fmt.Errorf("missing Content-Length header")
+}
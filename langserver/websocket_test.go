@@ -0,0 +1,176 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestParseContentLength(t *testing.T) {
+	cases := []struct {
+		name    string
+		header  string
+		want    int
+		wantErr bool
+	}{
+		{name: "simple", header: "Content-Length: 42", want: 42},
+		{name: "case insensitive and extra whitespace", header: "content-length:   7", want: 7},
+		{name: "alongside other headers", header: "X-Foo: bar\r\nContent-Length: 10", want: 10},
+		{name: "missing header", header: "X-Foo: bar", wantErr: true},
+		{name: "not a number", header: "Content-Length: abc", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseContentLength([]byte(tc.header))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tc.want {
+				t.Fatalf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// fakeWSConn records every message passed to WriteMessage, so tests can
+// assert on how wsReadWriter.Write split (or didn't split) its input into
+// frames, without opening a real network connection.
+type fakeWSConn struct {
+	mu       sync.Mutex
+	messages [][]byte
+}
+
+func (f *fakeWSConn) NextReader() (int, io.Reader, error) {
+	return 0, nil, io.EOF
+}
+
+func (f *fakeWSConn) WriteMessage(_ int, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.messages = append(f.messages, append([]byte(nil), data...))
+
+	return nil
+}
+
+func TestWsReadWriter_Write_BuffersHeaderAndBodyIntoOneFrame(t *testing.T) {
+	conn := &fakeWSConn{}
+	w := &wsReadWriter{conn: conn}
+
+	header := "Content-Length: 13\r\n\r\n"
+	body := `{"foo":"bar"}`
+
+	if _, err := w.Write([]byte(header)); err != nil {
+		t.Fatalf("Write(header): %v", err)
+	}
+
+	conn.mu.Lock()
+	framesAfterHeader := len(conn.messages)
+	conn.mu.Unlock()
+
+	if framesAfterHeader != 0 {
+		t.Fatalf("expected no frame to be sent before the body arrived, got %d", framesAfterHeader)
+	}
+
+	if _, err := w.Write([]byte(body)); err != nil {
+		t.Fatalf("Write(body): %v", err)
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if len(conn.messages) != 1 {
+		t.Fatalf("expected exactly one frame, got %d", len(conn.messages))
+	}
+
+	if got := string(conn.messages[0]); got != header+body {
+		t.Fatalf("frame = %q, want %q", got, header+body)
+	}
+}
+
+func TestWsReadWriter_Write_SplitsPipelinedMessages(t *testing.T) {
+	conn := &fakeWSConn{}
+	w := &wsReadWriter{conn: conn}
+
+	first := "Content-Length: 2\r\n\r\nok"
+	second := "Content-Length: 3\r\n\r\nyes"
+
+	if _, err := w.Write([]byte(first + second)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if len(conn.messages) != 2 {
+		t.Fatalf("expected two frames, got %d", len(conn.messages))
+	}
+
+	if got := string(conn.messages[0]); got != first {
+		t.Fatalf("frame[0] = %q, want %q", got, first)
+	}
+
+	if got := string(conn.messages[1]); got != second {
+		t.Fatalf("frame[1] = %q, want %q", got, second)
+	}
+}
+
+func TestWsReadWriter_Write_SerializesConcurrentWriters(t *testing.T) {
+	conn := &fakeWSConn{}
+	w := &wsReadWriter{conn: conn}
+
+	const writers = 20
+
+	var wg sync.WaitGroup
+
+	wg.Add(writers)
+
+	for i := 0; i < writers; i++ {
+		go func() {
+			defer wg.Done()
+
+			msg := "Content-Length: 2\r\n\r\nhi"
+			if _, err := w.Write([]byte(msg)); err != nil {
+				t.Errorf("Write: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if len(conn.messages) != writers {
+		t.Fatalf("expected %d complete frames, got %d", writers, len(conn.messages))
+	}
+
+	for i, msg := range conn.messages {
+		if got := string(msg); got != "Content-Length: 2\r\n\r\nhi" {
+			t.Fatalf("frame[%d] = %q, want a complete, unmangled message", i, got)
+		}
+	}
+}
@@ -13,13 +13,18 @@
 package prometheus
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/blang/semver"
@@ -28,22 +33,154 @@ import (
 	"github.com/prometheus/common/model"
 )
 
+// TLSConfig configures certificate based mTLS and CA verification against the
+// backing Prometheus instance.
+type TLSConfig struct {
+	// CAFile, if set, is used instead of the system certificate pool to verify
+	// the server certificate.
+	CAFile string
+	// CertFile and KeyFile, if both set, are presented to the server for mTLS.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables server certificate verification. It must be
+	// opted into explicitly; the previous hardcoded behaviour is no longer the default.
+	InsecureSkipVerify bool
+}
+
+// BasicAuth configures HTTP basic auth credentials. PasswordFile is reread on
+// every ChangeDataSource call, so credentials can be rotated without a restart.
+type BasicAuth struct {
+	Username     string
+	PasswordFile string
+}
+
+// PrometheusHTTPConfig configures TLS and authentication for the HTTP client
+// used to talk to the backing Prometheus instance.
+type PrometheusHTTPConfig struct {
+	TLSConfig TLSConfig
+
+	BasicAuth *BasicAuth
+
+	// BearerToken is used as-is if set. Otherwise, BearerTokenFile is reread on
+	// every ChangeDataSource call.
+	BearerToken     string
+	BearerTokenFile string
+
+	// Authorization, if set, is sent verbatim as the Authorization header,
+	// taking precedence over BasicAuth and the bearer token fields.
+	Authorization string
+}
+
 var (
 	// defining this global variable will avoid to initialized it each time
 	// and it will crash immediatly the server during the initialization in case the version is not well defined
 	requiredVersion = semver.MustParse("2.15.0") // nolint: gochecknoglobals
 )
 
-func buildGenericRoundTripper(connectionTimeout time.Duration) *http.Transport {
-	return &http.Transport{
+func buildTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify} // nolint: gas, gosec
+
+	if cfg.CAFile != "" {
+		caCert, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %w", cfg.CAFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse CA file %s", cfg.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s/%s: %w", cfg.CertFile, cfg.KeyFile, err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func buildGenericRoundTripper(connectionTimeout time.Duration, httpConfig PrometheusHTTPConfig) (http.RoundTripper, error) {
+	tlsConfig, err := buildTLSConfig(httpConfig.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
 			Timeout:   connectionTimeout,
 			KeepAlive: 30 * time.Second,
 		}).DialContext,
 		TLSHandshakeTimeout: 30 * time.Second,
-		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true}, // nolint: gas, gosec
+		TLSClientConfig:     tlsConfig,
+	}
+
+	authHeader, err := buildAuthorizationHeader(httpConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if authHeader == "" {
+		return transport, nil
 	}
+
+	return &authRoundTripper{authHeader: authHeader, next: transport}, nil
+}
+
+// buildAuthorizationHeader resolves the Authorization header value to send
+// with every request, rereading password/token files so rotated credentials
+// take effect on the next ChangeDataSource call.
+func buildAuthorizationHeader(httpConfig PrometheusHTTPConfig) (string, error) {
+	if httpConfig.Authorization != "" {
+		return httpConfig.Authorization, nil
+	}
+
+	if httpConfig.BearerToken != "" {
+		return "Bearer " + httpConfig.BearerToken, nil
+	}
+
+	if httpConfig.BearerTokenFile != "" {
+		token, err := ioutil.ReadFile(httpConfig.BearerTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read bearer token file %s: %w", httpConfig.BearerTokenFile, err)
+		}
+
+		return "Bearer " + strings.TrimSpace(string(token)), nil
+	}
+
+	if httpConfig.BasicAuth != nil {
+		password, err := ioutil.ReadFile(httpConfig.BasicAuth.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read basic auth password file %s: %w", httpConfig.BasicAuth.PasswordFile, err)
+		}
+
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(httpConfig.BasicAuth.Username, strings.TrimSpace(string(password)))
+
+		return req.Header.Get("Authorization"), nil
+	}
+
+	return "", nil
+}
+
+// authRoundTripper injects a precomputed Authorization header into every request.
+type authRoundTripper struct {
+	next       http.RoundTripper
+	authHeader string
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", rt.authHeader)
+
+	return rt.next.RoundTrip(req)
 }
 
 func buildStatusRequest(prometheusURL string) (*http.Request, error) {
@@ -88,12 +225,42 @@ type Client interface {
 	AllMetadata() (map[string][]v1.Metadata, error)
 	LabelNames(metricName string) ([]string, error)
 	LabelValues(label string) ([]model.LabelValue, error)
-	ChangeDataSource(prometheusURL string) error
+	ChangeDataSource(ctx context.Context, prometheusURL string) error
 	// GetURL is returning the url used to contact the prometheus server
 	// In case the instance is used directly in Prometheus, it should be the externalURL
 	GetURL() string
+	// CompatibilityState returns the outcome of the last compatibility probe
+	// against the backing Prometheus instance.
+	CompatibilityState() CompatibilityState
 }
 
+// CompatibilityState describes the outcome of the last probe of the backing
+// Prometheus instance's /api/v1/status/buildinfo endpoint.
+type CompatibilityState int32
+
+const (
+	// StateUnknown is the state before the first probe has completed.
+	StateUnknown CompatibilityState = iota
+	// StateCompatible means the backing Prometheus is >= requiredVersion.
+	StateCompatible
+	// StateNotCompatible means the backing Prometheus is reachable but older
+	// than requiredVersion.
+	StateNotCompatible
+	// StateUnreachable means the last probe failed to reach the backing
+	// Prometheus instance; a background reconciler is retrying.
+	StateUnreachable
+)
+
+// reconcileMinBackoff and reconcileMaxBackoff bound the exponential backoff
+// used to retry a failed compatibility probe.
+const (
+	reconcileMinBackoff = 2 * time.Second
+	reconcileMaxBackoff = time.Minute
+	// recheckInterval is how often a connected client re-probes compatibility,
+	// so a Prometheus upgrade is picked up without an editor restart.
+	recheckInterval = 5 * time.Minute
+)
+
 // httpClient is an implementation of the interface Client.
 // You should use this instance directly and not the other one (compatibleHTTPClient and notCompatibleHTTPClient)
 // because it will manage which sub instance of the Client to use (like a factory)
@@ -103,37 +270,63 @@ type httpClient struct {
 	mutex          sync.RWMutex
 	subClient      Client
 	url            string
+	httpConfig     PrometheusHTTPConfig
+
+	compatState int32 // atomic CompatibilityState
+
+	// generation is bumped under mutex by every ChangeDataSource call. A
+	// reconcile/recheck goroutine started by an earlier call compares its
+	// captured generation against this before writing subClient, so that a
+	// goroutine superseded by a newer ChangeDataSource can never clobber the
+	// client it installed, even if its ctx.Done() check lost the race.
+	generation int64
+
+	reconcileCancel context.CancelFunc
 }
 
-func NewClient(prometheusURL string) (Client, error) {
+func NewClient(ctx context.Context, prometheusURL string, httpConfig PrometheusHTTPConfig) (Client, error) {
 	c := &httpClient{
 		requestTimeout: 30 * time.Second,
+		httpConfig:     httpConfig,
 	}
-	if err := c.ChangeDataSource(prometheusURL); err != nil {
+	if err := c.ChangeDataSource(ctx, prometheusURL); err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
+// CompatibilityState returns the outcome of the last compatibility probe.
+func (c *httpClient) CompatibilityState() CompatibilityState {
+	return CompatibilityState(atomic.LoadInt32(&c.compatState))
+}
+
+func (c *httpClient) setCompatibilityState(s CompatibilityState) {
+	atomic.StoreInt32(&c.compatState, int32(s))
+}
+
 func (c *httpClient) Metadata(metric string) (v1.Metadata, error) {
+	defer observeAPICall("Metadata", time.Now())
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 	return c.subClient.Metadata(metric)
 }
 
 func (c *httpClient) AllMetadata() (map[string][]v1.Metadata, error) {
+	defer observeAPICall("AllMetadata", time.Now())
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 	return c.subClient.AllMetadata()
 }
 
 func (c *httpClient) LabelNames(name string) ([]string, error) {
+	defer observeAPICall("LabelNames", time.Now())
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 	return c.subClient.LabelNames(name)
 }
 
 func (c *httpClient) LabelValues(label string) ([]model.LabelValue, error) {
+	defer observeAPICall("LabelValues", time.Now())
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 	return c.subClient.LabelValues(label)
@@ -145,48 +338,191 @@ func (c *httpClient) GetURL() string {
 	return c.url
 }
 
-func (c *httpClient) ChangeDataSource(prometheusURL string) error {
+// ChangeDataSource points c at a new Prometheus instance. If the instance
+// cannot be reached, the previous subClient (or an emptyHTTPClient) is kept in
+// place and a background goroutine keeps probing with exponential backoff until
+// it connects, instead of failing permanently. Once connected, a compatible or
+// not compatible subClient is installed according to the instance's version,
+// and a background goroutine re-probes it every recheckInterval.
+func (c *httpClient) ChangeDataSource(ctx context.Context, prometheusURL string) (err error) {
+	defer func() {
+		observeChangeDataSource(err)
+	}()
+
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
+
+	if c.reconcileCancel != nil {
+		c.reconcileCancel()
+		c.reconcileCancel = nil
+	}
+
+	c.generation++
+	gen := c.generation
+
 	c.url = prometheusURL
 	if len(prometheusURL) == 0 {
 		// having an empty URL is a valid use case. So we should just initialized a "fake" http client
 		c.subClient = &emptyHTTPClient{}
+		c.setCompatibilityState(StateUnknown)
+		c.mutex.Unlock()
 		return nil
 	}
-	prometheusHTTPClient, err := api.NewClient(api.Config{
-		RoundTripper: buildGenericRoundTripper(c.requestTimeout * time.Second),
-		Address:      prometheusURL,
-	})
+
+	roundTripper, err := buildGenericRoundTripper(c.requestTimeout*time.Second, c.httpConfig)
 	if err != nil {
-		// always initialized the sub client to avoid any nil pointer usage
+		// a broken TLS/auth configuration will never heal on its own, so this is fatal
 		if c.subClient == nil {
 			c.subClient = &emptyHTTPClient{}
 		}
+		c.mutex.Unlock()
 		return err
 	}
 
-	isCompatible, err := c.isCompatible(prometheusURL)
-	if err != nil {
-		// always initialized the sub client to avoid any nil pointer usage
+	subClient, compatible, probeErr := c.probe(prometheusURL, roundTripper)
+	if probeErr != nil {
 		if c.subClient == nil {
 			c.subClient = &emptyHTTPClient{}
 		}
-		return err
+		c.setCompatibilityState(StateUnreachable)
+
+		reconcileCtx, cancel := context.WithCancel(ctx)
+		c.reconcileCancel = cancel
+		go c.reconcile(reconcileCtx, gen, prometheusURL, roundTripper)
+
+		c.mutex.Unlock()
+		// The failure is being retried in the background, so it isn't surfaced
+		// as a permanent error to the caller.
+		return nil
+	}
+
+	c.subClient = subClient
+	c.setCompatibilityState(compatible)
+
+	reconcileCtx, cancel := context.WithCancel(ctx)
+	c.reconcileCancel = cancel
+	go c.recheck(reconcileCtx, gen, prometheusURL, roundTripper)
+
+	c.mutex.Unlock()
+
+	return nil
+}
+
+// probe builds a subClient for prometheusURL and reports whether the backing
+// Prometheus instance is compatible.
+func (c *httpClient) probe(prometheusURL string, roundTripper http.RoundTripper) (Client, CompatibilityState, error) {
+	prometheusHTTPClient, err := api.NewClient(api.Config{
+		RoundTripper: roundTripper,
+		Address:      prometheusURL,
+	})
+	if err != nil {
+		return nil, StateUnreachable, err
+	}
+
+	isCompatible, err := c.isCompatible(prometheusURL)
+	if err != nil {
+		return nil, StateUnreachable, err
 	}
+
 	if isCompatible {
-		c.subClient = &compatibleHTTPClient{
+		return &compatibleHTTPClient{
 			requestTimeout:   c.requestTimeout,
 			prometheusClient: v1.NewAPI(prometheusHTTPClient),
+		}, StateCompatible, nil
+	}
+
+	return &notCompatibleHTTPClient{
+		requestTimeout:   c.requestTimeout,
+		prometheusClient: v1.NewAPI(prometheusHTTPClient),
+	}, StateNotCompatible, nil
+}
+
+// reconcile retries probe with exponential backoff until it succeeds or ctx
+// is cancelled, then hands off to recheck to keep polling for upgrades. gen
+// is the generation captured by the ChangeDataSource call that started this
+// goroutine; every write is conditioned on gen still being current, so a
+// goroutine superseded by a later ChangeDataSource can't install a stale
+// subClient even if it wins a race against ctx cancellation.
+func (c *httpClient) reconcile(ctx context.Context, gen int64, prometheusURL string, roundTripper http.RoundTripper) {
+	backoff := reconcileMinBackoff
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
 		}
-	} else {
-		c.subClient = &notCompatibleHTTPClient{
-			requestTimeout:   c.requestTimeout,
-			prometheusClient: v1.NewAPI(prometheusHTTPClient),
+
+		subClient, compatible, err := c.probe(prometheusURL, roundTripper)
+		if err != nil {
+			backoff *= 2
+			if backoff > reconcileMaxBackoff {
+				backoff = reconcileMaxBackoff
+			}
+
+			continue
 		}
+
+		if !c.installSubClient(gen, subClient, compatible) {
+			return
+		}
+
+		c.recheck(ctx, gen, prometheusURL, roundTripper)
+
+		return
 	}
+}
 
-	return nil
+// recheck periodically re-probes compatibility so that an in-place Prometheus
+// upgrade is picked up without requiring a new ChangeDataSource call. gen is
+// the generation captured by the call that started this goroutine; see
+// reconcile for why every write is conditioned on it.
+func (c *httpClient) recheck(ctx context.Context, gen int64, prometheusURL string, roundTripper http.RoundTripper) {
+	ticker := time.NewTicker(recheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		subClient, compatible, err := c.probe(prometheusURL, roundTripper)
+		if err != nil {
+			c.mutex.Lock()
+			if c.generation != gen {
+				c.mutex.Unlock()
+				return
+			}
+			c.setCompatibilityState(StateUnreachable)
+			c.mutex.Unlock()
+
+			go c.reconcile(ctx, gen, prometheusURL, roundTripper)
+
+			return
+		}
+
+		if !c.installSubClient(gen, subClient, compatible) {
+			return
+		}
+	}
+}
+
+// installSubClient writes subClient and compatible into c, but only if gen is
+// still the current generation. It reports whether the write happened; a
+// false result means a newer ChangeDataSource call has superseded the caller,
+// which should stop running.
+func (c *httpClient) installSubClient(gen int64, subClient Client, compatible CompatibilityState) bool {
+	c.mutex.Lock()
+	if c.generation != gen {
+		c.mutex.Unlock()
+		return false
+	}
+	c.subClient = subClient
+	c.setCompatibilityState(compatible)
+	c.mutex.Unlock()
+
+	return true
 }
 
 func (c *httpClient) isCompatible(prometheusURL string) (bool, error) {
@@ -194,8 +530,13 @@ func (c *httpClient) isCompatible(prometheusURL string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	roundTripper, err := buildGenericRoundTripper(c.requestTimeout*time.Second, c.httpConfig)
+	if err != nil {
+		return false, err
+	}
+
 	httpClient := &http.Client{
-		Transport: buildGenericRoundTripper(c.requestTimeout * time.Second),
+		Transport: roundTripper,
 		Timeout:   c.requestTimeout * time.Second,
 	}
 	resp, err := httpClient.Do(httpRequest)
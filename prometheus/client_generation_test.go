@@ -0,0 +1,79 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHTTPClient_InstallSubClient_CurrentGeneration(t *testing.T) {
+	c := &httpClient{generation: 3}
+
+	installed := &compatibleHTTPClient{}
+	if ok := c.installSubClient(3, installed, StateCompatible); !ok {
+		t.Fatal("expected install to succeed for the current generation")
+	}
+
+	if c.subClient != installed {
+		t.Fatal("subClient was not updated")
+	}
+
+	if got := c.CompatibilityState(); got != StateCompatible {
+		t.Fatalf("CompatibilityState() = %v, want %v", got, StateCompatible)
+	}
+}
+
+// TestHTTPClient_InstallSubClient_StaleGeneration verifies the race this
+// guards against: a reconcile/recheck goroutine started by an earlier
+// ChangeDataSource call must not be able to overwrite the subClient/
+// compatibility state installed by a later one, even if it loses the race
+// against ctx cancellation and reaches installSubClient anyway.
+func TestHTTPClient_InstallSubClient_StaleGeneration(t *testing.T) {
+	current := &compatibleHTTPClient{}
+	c := &httpClient{generation: 3, subClient: current}
+	c.setCompatibilityState(StateCompatible)
+
+	stale := &notCompatibleHTTPClient{}
+	if ok := c.installSubClient(2, stale, StateUnreachable); ok {
+		t.Fatal("expected install to fail for a stale generation")
+	}
+
+	if c.subClient != current {
+		t.Fatal("a stale generation must not overwrite a newer subClient")
+	}
+
+	if got := c.CompatibilityState(); got != StateCompatible {
+		t.Fatalf("a stale generation must not overwrite the compatibility state: got %v, want %v", got, StateCompatible)
+	}
+}
+
+func TestHTTPClient_ChangeDataSource_BumpsGeneration(t *testing.T) {
+	ctx := context.Background()
+
+	c := &httpClient{}
+	if err := c.ChangeDataSource(ctx, ""); err != nil {
+		t.Fatalf("ChangeDataSource: %v", err)
+	}
+
+	firstGen := c.generation
+
+	if err := c.ChangeDataSource(ctx, ""); err != nil {
+		t.Fatalf("ChangeDataSource: %v", err)
+	}
+
+	if c.generation != firstGen+1 {
+		t.Fatalf("generation = %d, want %d", c.generation, firstGen+1)
+	}
+}
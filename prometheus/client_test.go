@@ -0,0 +1,102 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write test file %s: %v", path, err)
+	}
+
+	return path
+}
+
+func TestBuildAuthorizationHeader_Precedence(t *testing.T) {
+	bearerTokenFile := writeFile(t, "file-token\n")
+	passwordFile := writeFile(t, "hunter2\n")
+
+	cases := []struct {
+		name   string
+		config PrometheusHTTPConfig
+		want   string
+	}{
+		{
+			name:   "nothing configured",
+			config: PrometheusHTTPConfig{},
+			want:   "",
+		},
+		{
+			name: "basic auth",
+			config: PrometheusHTTPConfig{
+				BasicAuth: &BasicAuth{Username: "alice", PasswordFile: passwordFile},
+			},
+			want: "Basic YWxpY2U6aHVudGVyMg==",
+		},
+		{
+			name: "bearer token file takes precedence over basic auth",
+			config: PrometheusHTTPConfig{
+				BearerTokenFile: bearerTokenFile,
+				BasicAuth:       &BasicAuth{Username: "alice", PasswordFile: passwordFile},
+			},
+			want: "Bearer file-token",
+		},
+		{
+			name: "bearer token takes precedence over bearer token file",
+			config: PrometheusHTTPConfig{
+				BearerToken:     "inline-token",
+				BearerTokenFile: bearerTokenFile,
+			},
+			want: "Bearer inline-token",
+		},
+		{
+			name: "explicit authorization header takes precedence over everything",
+			config: PrometheusHTTPConfig{
+				Authorization:   "Custom scheme-value",
+				BearerToken:     "inline-token",
+				BearerTokenFile: bearerTokenFile,
+				BasicAuth:       &BasicAuth{Username: "alice", PasswordFile: passwordFile},
+			},
+			want: "Custom scheme-value",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := buildAuthorizationHeader(tc.config)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildAuthorizationHeader_MissingFileIsAnError(t *testing.T) {
+	config := PrometheusHTTPConfig{BearerTokenFile: "/does/not/exist"}
+
+	if _, err := buildAuthorizationHeader(config); err == nil {
+		t.Fatal("expected an error for a missing bearer token file, got nil")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// apiCallLatency tracks the latency of calls httpClient makes to the backing
+// Prometheus API, broken down by call name.
+var apiCallLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{ // nolint: gochecknoglobals
+	Namespace: "promql_langserver",
+	Subsystem: "prometheus_client",
+	Name:      "api_call_duration_seconds",
+	Help:      "Latency of calls made to the backing Prometheus API, by call name.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"call"})
+
+// changeDataSourceTotal counts ChangeDataSource calls, by outcome ("success" or "failure").
+var changeDataSourceTotal = prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint: gochecknoglobals
+	Namespace: "promql_langserver",
+	Subsystem: "prometheus_client",
+	Name:      "change_data_source_total",
+	Help:      "Number of ChangeDataSource calls, by outcome.",
+}, []string{"outcome"})
+
+// RegisterMetrics registers this package's self-metrics with registerer. It
+// should be called at most once per registerer.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{apiCallLatency, changeDataSourceTotal} {
+		if err := registerer.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func observeAPICall(call string, start time.Time) {
+	apiCallLatency.WithLabelValues(call).Observe(time.Since(start).Seconds())
+}
+
+func observeChangeDataSource(err error) {
+	if err != nil {
+		changeDataSourceTotal.WithLabelValues("failure").Inc()
+		return
+	}
+
+	changeDataSourceTotal.WithLabelValues("success").Inc()
+}
@@ -0,0 +1,178 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// errNotTopLevelClient is returned by the sub-clients' ChangeDataSource: it is
+// only meaningful on the top-level httpClient returned by NewClient, which is
+// the one that owns reconnect/reconcile logic.
+var errNotTopLevelClient = errors.New("ChangeDataSource must be called on the client returned by NewClient")
+
+// emptyHTTPClient is used when there is no prometheusURL configured. Every
+// query returns an empty result rather than an error, so callers don't need to
+// special-case "no Prometheus configured". ChangeDataSource returns
+// errNotTopLevelClient, as on the other sub-clients.
+type emptyHTTPClient struct{}
+
+func (c *emptyHTTPClient) Metadata(string) (v1.Metadata, error) {
+	return v1.Metadata{}, nil
+}
+
+func (c *emptyHTTPClient) AllMetadata() (map[string][]v1.Metadata, error) {
+	return map[string][]v1.Metadata{}, nil
+}
+
+func (c *emptyHTTPClient) LabelNames(string) ([]string, error) {
+	return nil, nil
+}
+
+func (c *emptyHTTPClient) LabelValues(string) ([]model.LabelValue, error) {
+	return nil, nil
+}
+
+func (c *emptyHTTPClient) ChangeDataSource(context.Context, string) error {
+	return errNotTopLevelClient
+}
+
+func (c *emptyHTTPClient) GetURL() string {
+	return ""
+}
+
+func (c *emptyHTTPClient) CompatibilityState() CompatibilityState {
+	return StateUnknown
+}
+
+// compatibleHTTPClient talks to a Prometheus instance new enough to support
+// the full v1 API, including /api/v1/metadata.
+type compatibleHTTPClient struct {
+	requestTimeout   time.Duration
+	prometheusClient v1.API
+}
+
+func (c *compatibleHTTPClient) Metadata(metric string) (v1.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	defer cancel()
+
+	metadata, err := c.prometheusClient.Metadata(ctx, metric, "")
+	if err != nil {
+		return v1.Metadata{}, err
+	}
+
+	if values, ok := metadata[metric]; ok && len(values) > 0 {
+		return values[0], nil
+	}
+
+	return v1.Metadata{}, nil
+}
+
+func (c *compatibleHTTPClient) AllMetadata() (map[string][]v1.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	defer cancel()
+
+	return c.prometheusClient.Metadata(ctx, "", "")
+}
+
+func (c *compatibleHTTPClient) LabelNames(metricName string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	defer cancel()
+
+	var matches []string
+	if metricName != "" {
+		matches = []string{metricName}
+	}
+
+	names, _, err := c.prometheusClient.LabelNames(ctx, matches, time.Time{}, time.Time{})
+
+	return names, err
+}
+
+func (c *compatibleHTTPClient) LabelValues(label string) ([]model.LabelValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	defer cancel()
+
+	values, _, err := c.prometheusClient.LabelValues(ctx, label, nil, time.Time{}, time.Time{})
+
+	return []model.LabelValue(values), err
+}
+
+func (c *compatibleHTTPClient) ChangeDataSource(context.Context, string) error {
+	return errNotTopLevelClient
+}
+
+func (c *compatibleHTTPClient) GetURL() string {
+	return ""
+}
+
+func (c *compatibleHTTPClient) CompatibilityState() CompatibilityState {
+	return StateCompatible
+}
+
+// notCompatibleHTTPClient talks to a Prometheus instance older than
+// requiredVersion. /api/v1/metadata isn't available there, so Metadata and
+// AllMetadata are unsupported; label based completion still works.
+type notCompatibleHTTPClient struct {
+	requestTimeout   time.Duration
+	prometheusClient v1.API
+}
+
+func (c *notCompatibleHTTPClient) Metadata(string) (v1.Metadata, error) {
+	return v1.Metadata{}, errors.New("metric metadata requires Prometheus " + requiredVersion.String() + " or newer")
+}
+
+func (c *notCompatibleHTTPClient) AllMetadata() (map[string][]v1.Metadata, error) {
+	return nil, errors.New("metric metadata requires Prometheus " + requiredVersion.String() + " or newer")
+}
+
+func (c *notCompatibleHTTPClient) LabelNames(metricName string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	defer cancel()
+
+	var matches []string
+	if metricName != "" {
+		matches = []string{metricName}
+	}
+
+	names, _, err := c.prometheusClient.LabelNames(ctx, matches, time.Time{}, time.Time{})
+
+	return names, err
+}
+
+func (c *notCompatibleHTTPClient) LabelValues(label string) ([]model.LabelValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	defer cancel()
+
+	values, _, err := c.prometheusClient.LabelValues(ctx, label, nil, time.Time{}, time.Time{})
+
+	return []model.LabelValue(values), err
+}
+
+func (c *notCompatibleHTTPClient) ChangeDataSource(context.Context, string) error {
+	return errNotTopLevelClient
+}
+
+func (c *notCompatibleHTTPClient) GetURL() string {
+	return ""
+}
+
+func (c *notCompatibleHTTPClient) CompatibilityState() CompatibilityState {
+	return StateNotCompatible
+}